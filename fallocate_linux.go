@@ -0,0 +1,23 @@
+//go:build linux
+// +build linux
+
+package cookiejar
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocate grows f to at least size bytes without writing to it, so a
+// later shrinking write doesn't fail partway through on a near-full
+// filesystem.
+func fallocate(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.ENOSYS || err == syscall.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package cookiejar
+
+import "os"
+
+// fallocate is a no-op on platforms without a pre-allocation syscall we
+// support.
+func fallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package lockedfile provides advisory, cross-process file locking on
+// top of a plain lock file. It is intentionally minimal: callers open a
+// Mutex for a path, then Lock/RLock around a read-modify-write cycle and
+// Unlock when done.
+package lockedfile
+
+import (
+	"os"
+	"sync"
+)
+
+// Mutex is an advisory, cross-process lock backed by the file at
+// filename+".lock". The lock file is created on first use and is never
+// removed, so that unrelated processes locking the same path always
+// contend on the same inode.
+//
+// A Mutex also serializes Lock/RLock/Unlock within the process: the OS
+// file lock is acquired and the handle stored while mu is held, and only
+// released again by Unlock, so two goroutines sharing one Mutex (e.g.
+// both calling Jar.Save/Load concurrently) can't race on the underlying
+// *os.File.
+type Mutex struct {
+	filename string
+	mu       sync.Mutex
+	file     *os.File
+}
+
+// New returns a Mutex guarding filename. filename is typically the path
+// of the file being protected, not the lock file itself; the lock file
+// is derived from it.
+func New(filename string) *Mutex {
+	return &Mutex{filename: filename + ".lock"}
+}
+
+// Lock acquires an exclusive lock, blocking until it is available.
+func (m *Mutex) Lock() error {
+	return m.lock(true)
+}
+
+// RLock acquires a shared lock, blocking until it is available.
+func (m *Mutex) RLock() error {
+	return m.lock(false)
+}
+
+func (m *Mutex) lock(exclusive bool) error {
+	m.mu.Lock()
+	f, err := os.OpenFile(m.filename, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		m.mu.Unlock()
+		return err
+	}
+	m.file = f
+	return nil
+}
+
+// Unlock releases a lock previously acquired with Lock or RLock. Calling
+// Unlock without a preceding successful Lock/RLock is a no-op.
+func (m *Mutex) Unlock() error {
+	if m.file == nil {
+		return nil
+	}
+	defer m.mu.Unlock()
+	err := unlockFile(m.file)
+	if closeErr := m.file.Close(); err == nil {
+		err = closeErr
+	}
+	m.file = nil
+	return err
+}
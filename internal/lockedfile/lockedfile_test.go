@@ -0,0 +1,42 @@
+package lockedfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestMutexConcurrentLockUnlock exercises a single Mutex from many
+// goroutines at once. Run with -race: before the Mutex guarded its file
+// handle internally, this raced on the handle field and on *os.File
+// Close/Fd.
+func TestMutexConcurrentLockUnlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockedfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := New(filepath.Join(dir, "target"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := m.Lock(); err != nil {
+					t.Errorf("Lock: %v", err)
+					return
+				}
+				if err := m.Unlock(); err != nil {
+					t.Errorf("Unlock: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -2,6 +2,7 @@ package cookiejar
 
 import (
 	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -13,19 +14,48 @@ type atomicFile struct {
 	filename string
 	file     *os.File
 	ctime    time.Time
+	opts     atomicFileOptions
+}
+
+// atomicFileOptions controls how an atomicFile writes its temporary file and
+// commits it in place of the target file.
+type atomicFileOptions struct {
+	// PreAllocate, if set, grows the temporary file to at least the size
+	// of the file it will replace before any data is written to it, so
+	// that a shrinking write on an almost-full filesystem fails up front
+	// instead of midway through, leaving a file that is smaller than
+	// either version.
+	PreAllocate bool
 }
 
 var erratomicFileRetry = errors.New("original file newer than new contents")
 
 // Write file to temp and atomically move when everything else succeeds.
 func (a *atomicFile) create(filename string) (f *os.File, err error) {
+	return a.createOptions(filename, atomicFileOptions{})
+}
+
+// createOptions is like create but lets the caller control pre-allocation
+// via opts. fsync is not configurable: commit always syncs the temporary
+// file and its directory.
+func (a *atomicFile) createOptions(filename string, opts atomicFileOptions) (f *os.File, err error) {
 	a.filename = filename
+	a.opts = opts
 	dir, name := path.Split(filepath.ToSlash(filename))
 	a.file, err = ioutil.TempFile(dir, name)
-	if err == nil {
-		a.ctime = time.Now()
+	if err != nil {
+		return nil, err
 	}
-	return a.file, err
+	a.ctime = time.Now()
+	if opts.PreAllocate {
+		if fi, statErr := os.Stat(filename); statErr == nil {
+			if err := fallocate(a.file, fi.Size()); err != nil {
+				a.cancel()
+				return nil, err
+			}
+		}
+	}
+	return a.file, nil
 }
 
 func (a *atomicFile) isRetry(err error) bool {
@@ -56,24 +86,55 @@ func (a *atomicFile) commit() error {
 	if a.file == nil {
 		return nil
 	}
-	err := a.file.Sync()
+	// PreAllocate may have grown the temp file past what was actually
+	// written to it; drop the unwritten tail so the committed file ends
+	// at the real content length instead of with trailing padding.
+	written, err := a.file.Seek(0, io.SeekCurrent)
+	if err == nil {
+		err = a.file.Truncate(written)
+	}
+	if err == nil {
+		err = a.file.Sync()
+	}
+	tempName := a.file.Name()
 	if closeErr := a.file.Close(); err == nil {
 		err = closeErr
 	}
-	fi, err := os.Stat(a.filename)
-	if err == nil || os.IsNotExist(err) {
+	fi, statErr := os.Stat(a.filename)
+	if err == nil && (statErr == nil || os.IsNotExist(statErr)) {
 		// File was modified after we started writing to the new version.
 		if fi != nil && fi.ModTime().After(a.ctime) {
 			err = erratomicFileRetry
 		} else {
-			err = os.Rename(a.file.Name(), a.filename)
+			err = os.Rename(tempName, a.filename)
+			if err == nil {
+				err = syncDir(a.filename)
+			}
 		}
 	}
 	// Any err should result in full cleanup.
 	if err != nil {
 		a.cancel()
-	} else {
-		a.file.Close()
 	}
 	return err
 }
+
+// syncDir fsyncs the directory containing filename, so that a rename
+// into that directory survives a crash.
+func syncDir(filename string) error {
+	dir, _ := path.Split(filepath.ToSlash(filename))
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		// Not all platforms/filesystems support fsync on directories;
+		// ignore the error rather than failing a successful commit.
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package cookiejar
+
+import "time"
+
+// PersistCookie is the subset of a stored cookie's state exposed to a
+// PersistPolicy, used to decide whether it should be written out by
+// Jar.WriteTo or kept after being read back by Jar.ReadFrom.
+type PersistCookie struct {
+	Domain     string
+	Path       string
+	Name       string
+	Persistent bool
+	Expires    time.Time
+}
+
+// PersistPolicy controls which cookies Jar.Save writes to persistent
+// storage and which cookies Jar.Load keeps after reading them back. Set
+// it via Options.PersistPolicy when constructing a Jar; DefaultPersistPolicy
+// is used when none is configured.
+//
+// Custom policies can implement things like "only persist cookies for
+// these domains" or "cap total serialized size to N KB, evicting LRU" by
+// tracking whatever extra state they need across calls.
+type PersistPolicy interface {
+	// Persist reports whether c should be written out by WriteTo.
+	Persist(c PersistCookie) bool
+
+	// Accept reports whether c, just read back from storage, should be
+	// kept. now is the time ReadFrom was called.
+	Accept(c PersistCookie, now time.Time) bool
+}
+
+// DefaultPersistPolicy is the PersistPolicy used when a Jar's Options
+// don't set one. It drops session cookies (cookies with no Expires or
+// MaxAge) on save, and drops cookies that have already expired on either
+// save or load.
+var DefaultPersistPolicy PersistPolicy = defaultPersistPolicy{}
+
+type defaultPersistPolicy struct{}
+
+func (defaultPersistPolicy) Persist(c PersistCookie) bool {
+	if !c.Persistent {
+		return false
+	}
+	return c.Expires.IsZero() || c.Expires.After(time.Now())
+}
+
+func (defaultPersistPolicy) Accept(c PersistCookie, now time.Time) bool {
+	return c.Expires.IsZero() || c.Expires.After(now)
+}
+
+// persistPolicy returns the Jar's configured PersistPolicy, falling back
+// to DefaultPersistPolicy when none was set.
+func (j *Jar) persistPolicy() PersistPolicy {
+	if j.policy != nil {
+		return j.policy
+	}
+	return DefaultPersistPolicy
+}
+
+func persistCookie(e entry) PersistCookie {
+	return PersistCookie{
+		Domain:     e.Domain,
+		Path:       e.Path,
+		Name:       e.Name,
+		Persistent: e.Persistent,
+		Expires:    e.Expires,
+	}
+}
@@ -0,0 +1,47 @@
+package cookiejar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultPersistPolicyPersist(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		c    PersistCookie
+		want bool
+	}{
+		{"session cookie dropped", PersistCookie{Persistent: false}, false},
+		{"persistent, no expiry, kept", PersistCookie{Persistent: true}, true},
+		{"persistent, future expiry, kept", PersistCookie{Persistent: true, Expires: now.Add(time.Hour)}, true},
+		{"persistent, past expiry, dropped", PersistCookie{Persistent: true, Expires: now.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultPersistPolicy.Persist(tt.c); got != tt.want {
+				t.Errorf("Persist(%+v) = %v, want %v", tt.c, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultPersistPolicyAccept(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		c    PersistCookie
+		want bool
+	}{
+		{"no expiry accepted", PersistCookie{}, true},
+		{"future expiry accepted", PersistCookie{Expires: now.Add(time.Hour)}, true},
+		{"past expiry rejected", PersistCookie{Expires: now.Add(-time.Hour)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultPersistPolicy.Accept(tt.c, now); got != tt.want {
+				t.Errorf("Accept(%+v) = %v, want %v", tt.c, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,117 +5,170 @@
 package cookiejar
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"io"
-	"os"
+	"io/ioutil"
+	"time"
 )
 
-// Save uses j.WriteTo to save the cookies in j to a file at the path
-// they were loaded from with Load. Note that there is no locking
-// of the file, so concurrent calls to Save and Load can yield
-// corrupted or missing cookies.
+// Save uses j.WriteTo to save the cookies in j to the Storage backend it
+// was loaded from with Load or LoadStorage.
 //
-// It returns an error if Load was not called.
+// It returns an error if Load or LoadStorage was not called.
 func (j *Jar) Save() error {
-	if j.filename == "" {
+	j.mu.Lock()
+	storage := j.storage
+	j.mu.Unlock()
+	if storage == nil {
 		return errors.New("save called on non-loaded cookie jar")
 	}
-	for {
-		// Create a temporary file
-		var af atomicFile
-		f, err := af.create(j.filename)
-		if err != nil {
-			return err
-		}
-		// Write out to the temporary file
-		err = j.WriteTo(f)
-		if err != nil {
-			// On write error, remove the temp file
-			af.cancel()
-			return err
-		}
-		// Try replacing the original file with our temporary one.
-		// If the file to be replaced is newer, close() fails.
-		err = af.close()
-		// Success.
-		if err == nil {
-			return nil
-		}
-		// We failed, remove the temporary file.
-		af.cancel()
-		// Some error occurred, not related to retrying mechanism.
-		if !af.isRetry(err) {
-			return err
-		}
-		// Load the entries from the file to overwrite.
-		m := make(map[string]map[string]entry)
-		if err := loadJSON(j.filename, m); err != nil {
-			continue
-		}
-		// Merge them on top of ours (they are newer).
-		j.mu.Lock()
-		j.mergeEntries(m)
-		j.mu.Unlock()
+	ctx := context.Background()
+	if err := storage.Lock(ctx); err != nil {
+		return err
+	}
+	defer storage.Unlock(ctx)
+	var buf bytes.Buffer
+	if err := j.WriteTo(&buf); err != nil {
+		return err
 	}
+	return storage.Store(ctx, buf.Bytes())
 }
 
-// Load uses j.ReadFrom to read cookies
-// from the file at the given path. If the file does not exist,
-// no error will be returned and no cookies
+// Load uses j.ReadFrom to read cookies from the file at the given path.
+// If the file does not exist, no error will be returned and no cookies
 // will be loaded.
 //
-// The path will be stored in the jar and
-// used when j.Save is next called.
+// The path is stored in a default file Storage backend and used when
+// j.Save is next called. Load is a thin wrapper around LoadStorage; use
+// LoadStorage directly to plug in an alternative Storage implementation
+// such as NewDirStorage, NewMemoryStorage or NewEncryptedStorage.
 func (j *Jar) Load(path string) error {
-	j.mu.Lock()
-	defer j.mu.Unlock()
-	if err := loadJSON(path, j.entries); err != nil {
+	return j.LoadStorage(newFileStorage(path))
+}
+
+// LoadStorage uses j.ReadFrom to read cookies from storage into j.
+//
+// storage is stored in the jar and used when j.Save is next called.
+func (j *Jar) LoadStorage(storage Storage) error {
+	data, err := storage.Load(context.Background())
+	if err != nil {
 		return err
 	}
-	j.filename = path
+	if data != nil {
+		if err := j.ReadFrom(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	j.mu.Lock()
+	j.storage = storage
+	j.mu.Unlock()
 	return nil
 }
 
-// WriteTo writes all the cookies in the jar to w
-// as a JSON object.
+// WriteTo writes the cookies in the jar that the jar's PersistPolicy
+// decides to keep to w, as a JSON object. If the jar has a Key
+// configured, the JSON is prefixed with an HMAC-SHA256 tag computed over
+// it, so that ReadFrom can detect a tampered or corrupted file.
 func (j *Jar) WriteTo(w io.Writer) error {
-	// TODO don't store non-persistent cookies.
 	j.mu.Lock()
 	defer j.mu.Unlock()
-	return encodeJSON(w, j.entries)
-}
-
-// ReadFrom reads all the cookies from r
-// and stores them in the Jar.
-func (j *Jar) ReadFrom(r io.Reader) error {
-	// TODO verification and expiry on read.
-	j.mu.Lock()
-	defer j.mu.Unlock()
-	return decodeJSON(r, j.entries)
+	data, err := j.persistableBytes(j.entries)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
 }
 
-func (j *Jar) mergeEntries(m map[string]map[string]entry) {
-	for k0 := range m {
-		if _, ok := j.entries[k0]; !ok {
-			j.entries[k0] = make(map[string]entry)
+// persistableBytes filters entries down to what j's PersistPolicy
+// decides to keep and serializes the result the same way WriteTo does,
+// including the HMAC-SHA256 framing when j has a Key configured. It
+// exists so that any code path writing to a Jar's Storage backend - not
+// just Save/WriteTo, but also Update's commitUpdate - applies the same
+// "don't persist session/expired cookies" and tamper-detection rules,
+// instead of duplicating (and risking drifting from) WriteTo's logic.
+//
+// j.policy and j.key are set once when the Jar is constructed and never
+// mutated afterwards, so persistableBytes reads them directly without
+// requiring the caller to hold j.mu; only entries itself needs to be a
+// stable snapshot the caller owns.
+func (j *Jar) persistableBytes(entries map[string]map[string]entry) ([]byte, error) {
+	policy := j.persistPolicy()
+	out := make(map[string]map[string]entry, len(entries))
+	for host, cookies := range entries {
+		kept := make(map[string]entry)
+		for key, e := range cookies {
+			if policy.Persist(persistCookie(e)) {
+				kept[key] = e
+			}
 		}
-		for k1 := range m[k0] {
-			j.entries[k0][k1] = m[k0][k1]
+		if len(kept) > 0 {
+			out[host] = kept
 		}
 	}
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, out); err != nil {
+		return nil, err
+	}
+	if j.key == nil {
+		return buf.Bytes(), nil
+	}
+	mac := hmac.New(sha256.New, j.key)
+	mac.Write(buf.Bytes())
+	tagged := make([]byte, 0, sha256.Size+buf.Len())
+	tagged = append(tagged, mac.Sum(nil)...)
+	tagged = append(tagged, buf.Bytes()...)
+	return tagged, nil
 }
 
-func loadJSON(path string, m map[string]map[string]entry) error {
-	f, err := os.Open(path)
+// ReadFrom reads cookies from r and merges the ones the jar's
+// PersistPolicy accepts into the Jar, discarding already-expired entries
+// instead of resurrecting them. If the jar has a Key configured, r is
+// expected to start with the HMAC-SHA256 tag written by WriteTo, and
+// ReadFrom fails if it doesn't match.
+func (j *Jar) ReadFrom(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+		return err
+	}
+	if j.key != nil {
+		if len(data) < sha256.Size {
+			return errors.New("cookiejar: persisted data is too short to contain its HMAC tag")
 		}
+		tag, payload := data[:sha256.Size], data[sha256.Size:]
+		mac := hmac.New(sha256.New, j.key)
+		mac.Write(payload)
+		if !hmac.Equal(tag, mac.Sum(nil)) {
+			return errors.New("cookiejar: persisted data failed HMAC verification")
+		}
+		data = payload
+	}
+	m := make(map[string]map[string]entry)
+	if err := decodeJSON(bytes.NewReader(data), m); err != nil {
 		return err
 	}
-	defer f.Close()
-	return decodeJSON(f, m)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	policy := j.persistPolicy()
+	now := time.Now()
+	for host, cookies := range m {
+		for key, e := range cookies {
+			if !policy.Accept(persistCookie(e), now) {
+				continue
+			}
+			if _, ok := j.entries[host]; !ok {
+				j.entries[host] = make(map[string]entry)
+			}
+			j.entries[host][key] = e
+		}
+	}
+	return nil
 }
 
 func encodeJSON(w io.Writer, m map[string]map[string]entry) error {
@@ -0,0 +1,61 @@
+package cookiejar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSaveLoadShrinkingWithHMAC is a regression test for the interaction
+// between fileStorage's PreAllocate: true and WriteTo/ReadFrom's HMAC
+// framing: a Save that shrinks the cookie set must not leave trailing
+// padding from the pre-allocated temp file in the committed file, or the
+// next Load's HMAC check fails even though nothing was tampered with.
+func TestSaveLoadShrinkingWithHMAC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar-serialize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cookies.json")
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	j := &Jar{entries: make(map[string]map[string]entry), key: key}
+	if err := j.LoadStorage(newFileStorage(path)); err != nil {
+		t.Fatalf("LoadStorage: %v", err)
+	}
+
+	// Save a large jar first, so the file (and the temp file
+	// PreAllocate grows to match it) starts out large.
+	for i := 0; i < 50; i++ {
+		host := fmt.Sprintf("host%d.example.com", i)
+		j.entries[host] = map[string]entry{
+			"a": {Name: "a", Persistent: true, Expires: time.Now().Add(time.Hour)},
+		}
+	}
+	if err := j.Save(); err != nil {
+		t.Fatalf("Save (large): %v", err)
+	}
+
+	// Shrink drastically and save again.
+	j.entries = map[string]map[string]entry{
+		"host0.example.com": {"a": {Name: "a", Persistent: true, Expires: time.Now().Add(time.Hour)}},
+	}
+	if err := j.Save(); err != nil {
+		t.Fatalf("Save (shrunk): %v", err)
+	}
+
+	// A fresh Jar loading the same file must see exactly the shrunk
+	// content, with no leftover padding tripping HMAC verification.
+	j2 := &Jar{entries: make(map[string]map[string]entry), key: key}
+	if err := j2.LoadStorage(newFileStorage(path)); err != nil {
+		t.Fatalf("LoadStorage after shrink: %v", err)
+	}
+	if len(j2.entries) != 1 {
+		t.Fatalf("loaded %d hosts, want 1", len(j2.entries))
+	}
+}
@@ -0,0 +1,162 @@
+package cookiejar
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// maxUpdateRetries bounds how many attempts in all (the first one
+// included) Update makes, retrying after each race lost to another
+// concurrent Update, before giving up.
+const maxUpdateRetries = 10
+
+// ErrUpdateConflict is returned by Update when it lost a race with
+// another concurrent Update on the same Jar too many times in a row.
+var ErrUpdateConflict = errors.New("cookiejar: Update conflicted with concurrent updates too many times")
+
+// Snapshot is a deep copy of a Jar's in-memory cookies, handed to the
+// callback passed to Jar.Update or Jar.View. It deliberately doesn't
+// expose the internal entry representation; instead it offers a few bulk
+// operations: listing its hosts, dropping every cookie of a host, and
+// dropping expired cookies. It has no operation for rewriting a domain's
+// cookies.
+type Snapshot struct {
+	entries map[string]map[string]entry
+}
+
+// Hosts returns the host keys present in the snapshot, in the form
+// DeleteHost takes them. Their order is not defined.
+func (s *Snapshot) Hosts() []string {
+	hosts := make([]string, 0, len(s.entries))
+	for host := range s.entries {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// DeleteHost removes every cookie stored under host.
+func (s *Snapshot) DeleteHost(host string) {
+	delete(s.entries, host)
+}
+
+// DeleteExpired removes every persistent cookie whose Expires time is
+// before now.
+func (s *Snapshot) DeleteExpired(now time.Time) {
+	for host, cookies := range s.entries {
+		for key, e := range cookies {
+			if e.Persistent && !e.Expires.IsZero() && e.Expires.Before(now) {
+				delete(cookies, key)
+			}
+		}
+		if len(cookies) == 0 {
+			delete(s.entries, host)
+		}
+	}
+}
+
+func copyEntries(m map[string]map[string]entry) map[string]map[string]entry {
+	out := make(map[string]map[string]entry, len(m))
+	for host, cookies := range m {
+		c := make(map[string]entry, len(cookies))
+		for key, e := range cookies {
+			c[key] = e
+		}
+		out[host] = c
+	}
+	return out
+}
+
+// Update gives fn a deep copy of j's current cookies to mutate. If fn
+// returns nil, the copy replaces j's cookies; if j was loaded from a
+// Storage backend, the new cookies are also atomically persisted before
+// Update returns. If fn returns an error, or persisting fails, j is left
+// unchanged.
+//
+// Update detects conflicts with other concurrent calls to Update on the
+// same Jar (using an internal version counter) and retries fn against a
+// fresh snapshot, giving up with ErrUpdateConflict after
+// maxUpdateRetries attempts; fn should therefore be safe to call more
+// than once. This does NOT cover cookies mutated outside of Update/View,
+// for example by ordinary SetCookies calls racing with Update from
+// concurrent HTTP traffic: those aren't versioned, so such a mutation
+// happening between Update's snapshot and its final commit can still be
+// silently overwritten.
+func (j *Jar) Update(fn func(*Snapshot) error) error {
+	for attempt := 0; attempt < maxUpdateRetries; attempt++ {
+		j.mu.Lock()
+		version := j.version
+		snap := &Snapshot{entries: copyEntries(j.entries)}
+		j.mu.Unlock()
+
+		if err := fn(snap); err != nil {
+			return err
+		}
+
+		committed, err := j.commitUpdate(version, snap)
+		if err != nil {
+			return err
+		}
+		if committed {
+			return nil
+		}
+		// j.version moved under us: another Update committed first.
+		// Retry against a fresh snapshot.
+	}
+	return ErrUpdateConflict
+}
+
+// commitUpdate applies snap to j if j hasn't changed since version was
+// read, reporting whether it did so.
+//
+// j.commitMu is held for the whole version-check-through-swap sequence,
+// serializing commitUpdate against itself: without it, two concurrent
+// Updates that both read the same starting version could each pass the
+// version check and both reach storage.Store, with whichever one
+// physically writes last winning on disk regardless of which one won
+// the in-memory swap. j.mu is only taken for the brief reads/writes of
+// j's fields in between, so the cross-process storage.Lock/Store round
+// trip still doesn't stall other goroutines calling
+// Cookies/SetCookies/View on j the way holding j.mu for it would.
+func (j *Jar) commitUpdate(version uint64, snap *Snapshot) (bool, error) {
+	j.commitMu.Lock()
+	defer j.commitMu.Unlock()
+
+	j.mu.Lock()
+	if j.version != version {
+		j.mu.Unlock()
+		return false, nil
+	}
+	storage := j.storage
+	j.mu.Unlock()
+
+	if storage != nil {
+		data, err := j.persistableBytes(snap.entries)
+		if err != nil {
+			return false, err
+		}
+		ctx := context.Background()
+		if err := storage.Lock(ctx); err != nil {
+			return false, err
+		}
+		defer storage.Unlock(ctx)
+		if err := storage.Store(ctx, data); err != nil {
+			return false, err
+		}
+	}
+
+	j.mu.Lock()
+	j.entries = snap.entries
+	j.version++
+	j.mu.Unlock()
+	return true, nil
+}
+
+// View gives fn a deep, read-only copy of j's current cookies. Any
+// mutation fn makes to the copy is discarded.
+func (j *Jar) View(fn func(*Snapshot) error) error {
+	j.mu.Lock()
+	snap := &Snapshot{entries: copyEntries(j.entries)}
+	j.mu.Unlock()
+	return fn(snap)
+}
@@ -0,0 +1,326 @@
+package cookiejar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestJar(t *testing.T) *Jar {
+	t.Helper()
+	j := &Jar{entries: make(map[string]map[string]entry)}
+	if err := j.LoadStorage(NewMemoryStorage()); err != nil {
+		t.Fatalf("LoadStorage: %v", err)
+	}
+	return j
+}
+
+func TestUpdatePersistsAndSwapsEntries(t *testing.T) {
+	j := newTestJar(t)
+
+	err := j.Update(func(s *Snapshot) error {
+		s.entries["example.com"] = map[string]entry{
+			"a": {Name: "a", Persistent: true, Expires: time.Now().Add(time.Hour)},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, ok := j.entries["example.com"]; !ok {
+		t.Fatalf("Update did not apply the mutated snapshot to j.entries")
+	}
+
+	// A fresh Jar loading the same storage must see the persisted cookie.
+	j2 := &Jar{entries: make(map[string]map[string]entry)}
+	if err := j2.LoadStorage(j.storage); err != nil {
+		t.Fatalf("LoadStorage: %v", err)
+	}
+	if _, ok := j2.entries["example.com"]; !ok {
+		t.Fatalf("Update did not persist to storage: got %v", j2.entries)
+	}
+}
+
+// TestUpdateDoesNotPersistNonPersistentCookies is a regression test for
+// commitUpdate routing through the same PersistPolicy filtering as
+// WriteTo/Save: a session cookie added via Update must stay out of
+// storage even though it stays in the in-memory jar, exactly like a
+// session cookie added via SetCookies and then saved with Jar.Save.
+func TestUpdateDoesNotPersistNonPersistentCookies(t *testing.T) {
+	j := newTestJar(t)
+
+	err := j.Update(func(s *Snapshot) error {
+		s.entries["example.com"] = map[string]entry{
+			"session": {Name: "session", Persistent: false},
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, ok := j.entries["example.com"]["session"]; !ok {
+		t.Fatalf("Update dropped the cookie from in-memory entries; it should only be excluded from storage")
+	}
+
+	data, err := j.storage.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var m map[string]map[string]entry
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["example.com"]; ok {
+		t.Fatalf("non-persistent cookie was written to storage via Update: %v", m)
+	}
+}
+
+// blockingStorage wraps MemoryStorage so a test can hold a Store call
+// open until it chooses to release it, to reproduce races between
+// concurrent commitUpdate calls deterministically.
+type blockingStorage struct {
+	*MemoryStorage
+	block chan struct{}
+}
+
+func (s *blockingStorage) Store(ctx context.Context, data []byte) error {
+	<-s.block
+	return s.MemoryStorage.Store(ctx, data)
+}
+
+// TestCommitUpdateSerializesConcurrentStorageWrites is a regression test
+// for the race where two commitUpdate calls that both read the same
+// starting version could each pass the version check and both reach
+// storage.Store, with whichever physically wrote last winning on disk
+// regardless of which won the in-memory swap. It directly drives
+// commitUpdate (rather than Update) with a Storage gated on a channel so
+// the "losing" call is still in flight when the "winning" call would
+// finish, and asserts the loser never reaches storage at all.
+func TestCommitUpdateSerializesConcurrentStorageWrites(t *testing.T) {
+	j := newTestJar(t)
+	block := make(chan struct{})
+	bs := &blockingStorage{MemoryStorage: j.storage.(*MemoryStorage), block: block}
+	j.storage = bs
+
+	j.mu.Lock()
+	version := j.version
+	j.mu.Unlock()
+
+	snapA := &Snapshot{entries: copyEntries(j.entries)}
+	snapA.entries["a.example.com"] = map[string]entry{"x": {Name: "x", Persistent: true, Expires: time.Now().Add(time.Hour)}}
+	snapB := &Snapshot{entries: copyEntries(j.entries)}
+	snapB.entries["b.example.com"] = map[string]entry{"y": {Name: "y", Persistent: true, Expires: time.Now().Add(time.Hour)}}
+
+	type result struct {
+		committed bool
+		err       error
+	}
+	resultA := make(chan result, 1)
+	go func() {
+		committed, err := j.commitUpdate(version, snapA)
+		resultA <- result{committed, err}
+	}()
+
+	// Give A a chance to reach storage.Store (and block there) before B starts.
+	time.Sleep(20 * time.Millisecond)
+
+	resultB := make(chan result, 1)
+	go func() {
+		committed, err := j.commitUpdate(version, snapB)
+		resultB <- result{committed, err}
+	}()
+
+	select {
+	case <-resultB:
+		t.Fatal("commitUpdate B returned before A's Store unblocked: the two commits weren't serialized")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block)
+
+	gotA := <-resultA
+	if gotA.err != nil {
+		t.Fatalf("commitUpdate A: %v", gotA.err)
+	}
+	if !gotA.committed {
+		t.Fatal("commitUpdate A did not commit")
+	}
+
+	gotB := <-resultB
+	if gotB.err != nil {
+		t.Fatalf("commitUpdate B: %v", gotB.err)
+	}
+	if gotB.committed {
+		t.Fatal("commitUpdate B committed despite a stale version, clobbering A's write")
+	}
+
+	data, err := bs.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var m map[string]map[string]entry
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["a.example.com"]; !ok {
+		t.Fatalf("storage lost A's winning write: %v", m)
+	}
+	if _, ok := m["b.example.com"]; ok {
+		t.Fatalf("storage was overwritten by B's stale write: %v", m)
+	}
+}
+
+func TestUpdateErrorLeavesJarUnchanged(t *testing.T) {
+	j := newTestJar(t)
+	j.entries["example.com"] = map[string]entry{"a": {Name: "a"}}
+
+	wantErr := errors.New("fn failed")
+	err := j.Update(func(s *Snapshot) error {
+		s.DeleteHost("example.com")
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("Update error = %v, want %v", err, wantErr)
+	}
+	if _, ok := j.entries["example.com"]; !ok {
+		t.Fatalf("Update applied a mutation despite fn returning an error")
+	}
+}
+
+func TestViewDoesNotMutateJar(t *testing.T) {
+	j := newTestJar(t)
+	j.entries["example.com"] = map[string]entry{"a": {Name: "a"}}
+
+	err := j.View(func(s *Snapshot) error {
+		s.DeleteHost("example.com")
+		s.entries["other.com"] = map[string]entry{"b": {Name: "b"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	if _, ok := j.entries["example.com"]; !ok {
+		t.Fatalf("View mutation leaked into j.entries: example.com was removed")
+	}
+	if _, ok := j.entries["other.com"]; ok {
+		t.Fatalf("View mutation leaked into j.entries: other.com was added")
+	}
+}
+
+func TestSnapshotHostsAndDeleteExpired(t *testing.T) {
+	j := newTestJar(t)
+	now := time.Now()
+	j.entries = map[string]map[string]entry{
+		"fresh.com": {"a": {Name: "a", Persistent: true, Expires: now.Add(time.Hour)}},
+		"stale.com": {"b": {Name: "b", Persistent: true, Expires: now.Add(-time.Hour)}},
+	}
+
+	var gotHosts []string
+	if err := j.View(func(s *Snapshot) error {
+		gotHosts = s.Hosts()
+		s.DeleteExpired(now)
+		if _, ok := s.entries["stale.com"]; ok {
+			t.Fatalf("DeleteExpired did not remove stale.com")
+		}
+		if _, ok := s.entries["fresh.com"]; !ok {
+			t.Fatalf("DeleteExpired removed fresh.com")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	if len(gotHosts) != 2 {
+		t.Fatalf("Hosts = %v, want 2 entries", gotHosts)
+	}
+}
+
+// TestUpdateConcurrentConflictRetries is a regression test for the
+// version-counter conflict detection added alongside commitUpdate: many
+// goroutines calling Update concurrently, each adding their own key to
+// the same host, must all see their key survive in the final jar
+// instead of one's addition silently clobbering another's.
+func TestUpdateConcurrentConflictRetries(t *testing.T) {
+	j := newTestJar(t)
+	j.entries["counter.example.com"] = map[string]entry{}
+
+	const goroutines = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("k%d", i)
+			errs <- j.Update(func(s *Snapshot) error {
+				s.entries["counter.example.com"][key] = entry{Name: key}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent Update: %v", err)
+		}
+	}
+
+	if got := len(j.entries["counter.example.com"]); got != goroutines {
+		t.Fatalf("counter.example.com has %d keys, want %d: a concurrent Update clobbered another's", got, goroutines)
+	}
+}
+
+// TestCommitUpdateDetectsConflict exercises commitUpdate directly: if
+// j.version has moved since the snapshot was taken, commitUpdate must
+// report that it didn't commit instead of overwriting the newer state.
+func TestCommitUpdateDetectsConflict(t *testing.T) {
+	j := newTestJar(t)
+	j.entries["a.example.com"] = map[string]entry{"x": {Name: "x"}}
+
+	j.mu.Lock()
+	version := j.version
+	snap := &Snapshot{entries: copyEntries(j.entries)}
+	j.mu.Unlock()
+
+	// Someone else commits first, bumping j.version.
+	if err := j.Update(func(s *Snapshot) error {
+		s.entries["b.example.com"] = map[string]entry{"y": {Name: "y"}}
+		return nil
+	}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	snap.entries["a.example.com"]["x"] = entry{Name: "x-stale"}
+	committed, err := j.commitUpdate(version, snap)
+	if err != nil {
+		t.Fatalf("commitUpdate: %v", err)
+	}
+	if committed {
+		t.Fatalf("commitUpdate committed a stale snapshot despite a version conflict")
+	}
+	if _, ok := j.entries["b.example.com"]; !ok {
+		t.Fatalf("the winning Update's result was overwritten by the stale commitUpdate")
+	}
+}
+
+func TestUpdateGivesUpAfterMaxRetries(t *testing.T) {
+	j := newTestJar(t)
+	j.entries["a.example.com"] = map[string]entry{"x": {Name: "x"}}
+
+	err := j.Update(func(s *Snapshot) error {
+		// Every attempt bumps j.version behind fn's back, so the outer
+		// Update can never win the race and must eventually give up.
+		j.mu.Lock()
+		j.version++
+		j.mu.Unlock()
+		return nil
+	})
+	if err != ErrUpdateConflict {
+		t.Fatalf("Update error = %v, want ErrUpdateConflict", err)
+	}
+}
@@ -0,0 +1,50 @@
+package cookiejar
+
+import (
+	"context"
+
+	"github.com/dullgiulio/persistent-cookiejar/internal/lockedfile"
+)
+
+// Storage is the persistence backend used by a Jar to load and save its
+// cookies. The default backend, used by Load and Save, keeps all cookies
+// in a single JSON file. Alternative implementations can shard cookies
+// across many files (see DirStorage), keep them in memory for tests (see
+// NewMemoryStorage), or wrap another Storage to encrypt it at rest (see
+// NewEncryptedStorage).
+type Storage interface {
+	// Load returns the raw serialized cookie data previously passed to
+	// Store, or nil if nothing has been stored yet.
+	Load(ctx context.Context) ([]byte, error)
+
+	// Store persists the raw serialized cookie data, replacing whatever
+	// was stored before.
+	Store(ctx context.Context, data []byte) error
+
+	// Delete removes any persisted data.
+	Delete(ctx context.Context) error
+
+	// Lock acquires a backend-wide exclusive lock, held by the caller for
+	// the duration of a read-modify-write cycle. Backends that have no
+	// meaningful notion of locking (e.g. MemoryStorage) may treat this as
+	// a no-op.
+	Lock(ctx context.Context) error
+
+	// Unlock releases a lock acquired with Lock.
+	Unlock(ctx context.Context) error
+}
+
+// fileStorage is the default Storage backend: it keeps every cookie in a
+// single JSON file, written atomically via atomicFile. Reads and writes
+// are guarded by an advisory lock file so that multiple processes (e.g.
+// a CLI and a long-running daemon) can share the same cookie file.
+type fileStorage struct {
+	filename string
+	lock     *lockedfile.Mutex
+}
+
+// newFileStorage returns the default Storage backend, storing cookies as
+// a single JSON file at filename.
+func newFileStorage(filename string) *fileStorage {
+	return &fileStorage{filename: filename, lock: lockedfile.New(filename)}
+}
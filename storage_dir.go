@@ -0,0 +1,157 @@
+package cookiejar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dullgiulio/persistent-cookiejar/internal/lockedfile"
+)
+
+// DirStorage is a Storage backend that shards cookies across one small
+// file per registered domain inside a directory, instead of a single
+// JSON blob. Store always receives the whole jar's serialized bytes (that
+// is what Jar.Save produces), so DirStorage diffs each host's
+// re-marshaled entries against what's already on disk and only rewrites
+// the host files whose content actually changed, instead of rewriting
+// every host on every Save.
+//
+// DirStorage needs to inspect the cookie structure to shard it, so it
+// must be used directly as a Jar's Storage and not wrapped by a backend
+// that transforms the serialized bytes, such as EncryptedStorage; doing
+// so will make every Store fail when DirStorage tries to unmarshal the
+// transformed bytes as cookie JSON.
+type DirStorage struct {
+	dir  string
+	lock *lockedfile.Mutex
+}
+
+// NewDirStorage returns a Storage backend that keeps one file per host
+// inside dir. The directory is created on first Lock or Store if it
+// does not already exist.
+func NewDirStorage(dir string) *DirStorage {
+	return &DirStorage{dir: dir, lock: lockedfile.New(filepath.Join(dir, "dirstorage"))}
+}
+
+func (s *DirStorage) hostFile(host string) string {
+	return filepath.Join(s.dir, url.QueryEscape(host)+".json")
+}
+
+func (s *DirStorage) Load(ctx context.Context) ([]byte, error) {
+	if _, err := os.Stat(s.dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := s.lock.RLock(); err != nil {
+		return nil, err
+	}
+	defer s.lock.Unlock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := make(map[string]map[string]entry)
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		host, err := url.QueryUnescape(strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var hostEntries map[string]entry
+		if err := json.Unmarshal(data, &hostEntries); err != nil {
+			return nil, err
+		}
+		m[host] = hostEntries
+	}
+	return json.Marshal(m)
+}
+
+func (s *DirStorage) Store(ctx context.Context, data []byte) error {
+	var m map[string]map[string]entry
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("dirstorage: data is not a serialized cookie jar (DirStorage cannot be wrapped by a Storage that transforms bytes, such as EncryptedStorage): %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	if existing, err := ioutil.ReadDir(s.dir); err == nil {
+		for _, fi := range existing {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+				continue
+			}
+			host, err := url.QueryUnescape(strings.TrimSuffix(fi.Name(), ".json"))
+			if err != nil {
+				continue
+			}
+			if _, ok := m[host]; !ok {
+				os.Remove(filepath.Join(s.dir, fi.Name()))
+			}
+		}
+	}
+	for host, hostEntries := range m {
+		data, err := json.Marshal(hostEntries)
+		if err != nil {
+			return err
+		}
+		hostFile := s.hostFile(host)
+		if existing, err := ioutil.ReadFile(hostFile); err == nil && bytes.Equal(existing, data) {
+			// This host's cookies didn't change: skip the rewrite.
+			continue
+		}
+		var af atomicFile
+		f, err := af.create(hostFile)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			af.cancel()
+			return err
+		}
+		if err := af.commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DirStorage) Delete(ctx context.Context) error {
+	err := os.RemoveAll(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Lock acquires an exclusive, cross-process lock on dir, held by the
+// caller for the duration of a Store (and, via Load's own internal
+// RLock, contended against concurrent reads too): Store's list-delete-
+// rewrite sequence touches the whole directory in several steps, so two
+// processes calling Jar.Save against the same dir need to be serialized
+// the same way fileStorage serializes writes to its single file.
+func (s *DirStorage) Lock(ctx context.Context) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return s.lock.Lock()
+}
+
+// Unlock releases the lock acquired with Lock.
+func (s *DirStorage) Unlock(ctx context.Context) error { return s.lock.Unlock() }
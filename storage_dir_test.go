@@ -0,0 +1,159 @@
+package cookiejar
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestDirStorageLockIsExclusiveAcrossInstances is a regression test for
+// Lock/Unlock: they used to be no-ops, so two DirStorage values sharing
+// dir (standing in for two processes) never contended at all. Now a
+// second Lock must block until the first Unlock, the same way
+// fileStorage's lock does.
+func TestDirStorageLockIsExclusiveAcrossInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar-dirstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := NewDirStorage(dir)
+	b := NewDirStorage(dir)
+	ctx := context.Background()
+
+	if err := a.Lock(ctx); err != nil {
+		t.Fatalf("a.Lock: %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		if err := b.Lock(ctx); err != nil {
+			t.Errorf("b.Lock: %v", err)
+		}
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("b.Lock returned while a still held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatalf("a.Unlock: %v", err)
+	}
+
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("b.Lock did not return after a.Unlock")
+	}
+	if err := b.Unlock(ctx); err != nil {
+		t.Fatalf("b.Unlock: %v", err)
+	}
+}
+
+func TestDirStorageRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar-dirstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewDirStorage(dir)
+	ctx := context.Background()
+
+	m := map[string]map[string]entry{
+		"a.example.com": {"session": {Name: "session", Persistent: true}},
+		"b.example.com": {"id": {Name: "id", Persistent: true}},
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store(ctx, data); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	got, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var gotM map[string]map[string]entry
+	if err := json.Unmarshal(got, &gotM); err != nil {
+		t.Fatal(err)
+	}
+	if len(gotM) != 2 || len(gotM["a.example.com"]) != 1 || len(gotM["b.example.com"]) != 1 {
+		t.Fatalf("Load = %v, want 2 hosts with 1 cookie each", gotM)
+	}
+}
+
+func TestDirStorageOnlyRewritesChangedHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar-dirstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewDirStorage(dir)
+	ctx := context.Background()
+
+	m := map[string]map[string]entry{
+		"a.example.com": {"session": {Name: "session", Persistent: true}},
+		"b.example.com": {"id": {Name: "id", Persistent: true}},
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	aFile := s.hostFile("a.example.com")
+	bFile := s.hostFile("b.example.com")
+	aBefore, err := os.Stat(aFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only b's cookies change; a's re-marshaled content is byte-for-byte
+	// identical to what's already on disk.
+	m["b.example.com"]["id"] = entry{Name: "id", Persistent: true, Expires: time.Now().Add(time.Hour)}
+	data, err = json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Store(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	aAfter, err := os.Stat(aFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !aAfter.ModTime().Equal(aBefore.ModTime()) {
+		t.Fatalf("a.example.com file was rewritten even though its cookies didn't change")
+	}
+	if _, err := os.Stat(bFile); err != nil {
+		t.Fatalf("b.example.com file missing: %v", err)
+	}
+}
+
+func TestDirStorageRejectsNonJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookiejar-dirstorage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewDirStorage(dir)
+	if err := s.Store(context.Background(), []byte{0xde, 0xad, 0xbe, 0xef}); err == nil {
+		t.Fatal("Store with non-JSON data succeeded, want an error (e.g. from wrapping with EncryptedStorage)")
+	}
+}
@@ -0,0 +1,69 @@
+package cookiejar
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// EncryptedStorage wraps another Storage backend and encrypts the
+// serialized cookie bytes with AES-GCM before handing them to it,
+// decrypting them again on Load.
+type EncryptedStorage struct {
+	backend Storage
+	key     []byte
+}
+
+// NewEncryptedStorage returns a Storage that AES-GCM-encrypts data with
+// key before passing it to backend, and decrypts data read back from it.
+// key must be a valid AES key length (16, 24 or 32 bytes).
+func NewEncryptedStorage(backend Storage, key []byte) (*EncryptedStorage, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, err
+	}
+	return &EncryptedStorage{backend: backend, key: key}, nil
+}
+
+func (s *EncryptedStorage) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *EncryptedStorage) Load(ctx context.Context) ([]byte, error) {
+	data, err := s.backend.Load(ctx)
+	if err != nil || data == nil {
+		return data, err
+	}
+	gcm, err := s.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("cookiejar: encrypted cookie data is truncated")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func (s *EncryptedStorage) Store(ctx context.Context, data []byte) error {
+	gcm, err := s.gcm()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	ciphertext := gcm.Seal(nonce, nonce, data, nil)
+	return s.backend.Store(ctx, ciphertext)
+}
+
+func (s *EncryptedStorage) Delete(ctx context.Context) error { return s.backend.Delete(ctx) }
+func (s *EncryptedStorage) Lock(ctx context.Context) error   { return s.backend.Lock(ctx) }
+func (s *EncryptedStorage) Unlock(ctx context.Context) error { return s.backend.Unlock(ctx) }
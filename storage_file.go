@@ -0,0 +1,51 @@
+package cookiejar
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+)
+
+func (s *fileStorage) Load(ctx context.Context) ([]byte, error) {
+	if err := s.lock.RLock(); err != nil {
+		return nil, err
+	}
+	defer s.lock.Unlock()
+	f, err := os.Open(s.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func (s *fileStorage) Store(ctx context.Context, data []byte) error {
+	var af atomicFile
+	f, err := af.createOptions(s.filename, atomicFileOptions{PreAllocate: true})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		af.cancel()
+		return err
+	}
+	return af.commit()
+}
+
+func (s *fileStorage) Delete(ctx context.Context) error {
+	err := os.Remove(s.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Lock acquires an exclusive lock held for the whole read-modify-rename
+// cycle performed by Jar.Save.
+func (s *fileStorage) Lock(ctx context.Context) error { return s.lock.Lock() }
+
+// Unlock releases the lock acquired with Lock.
+func (s *fileStorage) Unlock(ctx context.Context) error { return s.lock.Unlock() }
@@ -0,0 +1,47 @@
+package cookiejar
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStorage is a Storage backend that keeps the serialized cookie
+// data in memory instead of on disk. It is mainly useful for tests that
+// want Jar's persistence semantics without touching the filesystem.
+type MemoryStorage struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewMemoryStorage returns a Storage backend with no persisted data.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return nil, nil
+	}
+	data := make([]byte, len(s.data))
+	copy(data, s.data)
+	return data, nil
+}
+
+func (s *MemoryStorage) Store(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append(s.data[:0], data...)
+	return nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = nil
+	return nil
+}
+
+func (s *MemoryStorage) Lock(ctx context.Context) error   { return nil }
+func (s *MemoryStorage) Unlock(ctx context.Context) error { return nil }
@@ -0,0 +1,65 @@
+package cookiejar
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMemoryStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+
+	data, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load on empty storage: %v", err)
+	}
+	if data != nil {
+		t.Fatalf("Load on empty storage = %q, want nil", data)
+	}
+
+	want := []byte(`{"example.com":{}}`)
+	if err := s.Store(ctx, want); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	got, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Load = %q, want %q", got, want)
+	}
+
+	if err := s.Delete(ctx); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	got, err = s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load after Delete: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("Load after Delete = %q, want nil", got)
+	}
+}
+
+func TestMemoryStorageLoadReturnsCopy(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	if err := s.Store(ctx, []byte("original")); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	got, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got[0] = 'X'
+
+	got2, err := s.Load(ctx)
+	if err != nil {
+		t.Fatalf("second Load: %v", err)
+	}
+	if string(got2) != "original" {
+		t.Fatalf("mutating a Load result affected the backend: got %q", got2)
+	}
+}